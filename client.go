@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+	gitlab "github.com/xanzy/go-gitlab"
+)
+
+const (
+	defaultGitLabHost     = "gitlab.com"
+	defaultAPIVersionPath = "api/v4"
+	graphqlPath           = "api/graphql"
+)
+
+// TokenType selects which header is used to authenticate hand-rolled
+// requests (GraphQL, trace) against GitLab, mirroring the scheme go-gitlab
+// uses for REST calls.
+type TokenType string
+
+const (
+	// PrivateToken sends the token via the PRIVATE-TOKEN header, GitLab's
+	// scheme for personal/project access tokens.
+	PrivateToken TokenType = "private"
+	// OAuthToken sends the token via a Bearer Authorization header, used
+	// for OAuth app tokens and GitLab CI's own CI_JOB_TOKEN-style auth.
+	OAuthToken TokenType = "oauth"
+)
+
+// GitLabClient wraps the go-gitlab REST client together with a retryable
+// HTTP client for the GraphQL/trace calls that go-gitlab doesn't cover, so
+// those call sites share one transport (retries, auth header) instead of
+// each building its own http.Client from scratch. go-gitlab's REST client
+// already retries 429/5xx internally via its own retryablehttp.Client, so
+// it's left with its default transport rather than being handed this one --
+// wrapping it a second time would mean every retry attempt it makes quietly
+// triggers a full retry cycle of its own. It also carries the resolved
+// GraphQL URL, since go-gitlab only knows about the REST endpoint.
+type GitLabClient struct {
+	REST       *gitlab.Client
+	HTTP       *http.Client
+	GraphQLURL string
+
+	token     string
+	tokenType TokenType
+}
+
+// NewGitLabClient builds a GitLabClient for the given GitLab host (empty
+// defaults to gitlab.com, so self-hosted instances work by just setting
+// `gitlab_host`) and API version path (empty defaults to "api/v4").
+func NewGitLabClient(token, host string, tokenType TokenType, apiVersionPath string) (*GitLabClient, error) {
+	if host == "" {
+		host = defaultGitLabHost
+	}
+	if tokenType == "" {
+		tokenType = PrivateToken
+	}
+	if apiVersionPath == "" {
+		apiVersionPath = defaultAPIVersionPath
+	}
+	apiVersionPath = strings.Trim(apiVersionPath, "/")
+	host = stripScheme(strings.TrimSuffix(strings.TrimSuffix(host, "/"), "/"+apiVersionPath))
+
+	baseURL := fmt.Sprintf("https://%s/%s", host, apiVersionPath)
+	graphqlURL := fmt.Sprintf("https://%s/%s", host, graphqlPath)
+
+	// GraphQL and trace requests aren't covered by go-gitlab's own client, so
+	// they get their own retryablehttp client for network errors, 429s, and
+	// 5xxs with exponential backoff.
+	retryClient := retryablehttp.NewClient()
+	retryClient.RetryMax = 5
+	retryClient.RetryWaitMin = 1 * time.Second
+	retryClient.RetryWaitMax = 30 * time.Second
+	retryClient.Logger = nil
+	httpClient := retryClient.StandardClient()
+
+	var gl *gitlab.Client
+	var err error
+	switch tokenType {
+	case OAuthToken:
+		gl, err = gitlab.NewOAuthClient(token, gitlab.WithBaseURL(baseURL))
+	default:
+		gl, err = gitlab.NewClient(token, gitlab.WithBaseURL(baseURL))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GitLab client: %w", err)
+	}
+
+	return &GitLabClient{
+		REST:       gl,
+		HTTP:       httpClient,
+		GraphQLURL: graphqlURL,
+		token:      token,
+		tokenType:  tokenType,
+	}, nil
+}
+
+// NewGitLabClientFromEnv builds a GitLabClient using the `gitlab_host` /
+// `CI_SERVER_URL`, `gitlab_token_type`, and `gitlab_api_version` environment
+// variables, so both the trigger flow and the `trace` subcommand resolve the
+// same way.
+func NewGitLabClientFromEnv(token string) (*GitLabClient, error) {
+	return NewGitLabClient(token, resolveGitLabHost(), TokenType(os.Getenv("gitlab_token_type")), apiVersionPathFromEnv())
+}
+
+// AuthHeader returns the header name and value to use for authenticating
+// hand-rolled requests (GraphQL, trace), matching whatever auth scheme the
+// client was configured with.
+func (c *GitLabClient) AuthHeader() (string, string) {
+	if c.tokenType == OAuthToken {
+		return "Authorization", "Bearer " + c.token
+	}
+	return "PRIVATE-TOKEN", c.token
+}
+
+// resolveGitLabHost picks the GitLab host to talk to: an explicit
+// `gitlab_host` wins, otherwise we fall back to GitLab CI's own
+// `CI_SERVER_URL` (set automatically when this step itself runs on GitLab
+// CI), and finally gitlab.com. Both env vars are accepted in either form --
+// bare host ("gitlab.example.com") or full URL ("https://gitlab.example.com")
+// -- since `CI_SERVER_URL` is always the latter and `gitlab_host` is most
+// naturally set the same way on a self-hosted instance.
+func resolveGitLabHost() string {
+	if host := strings.TrimSpace(os.Getenv("gitlab_host")); host != "" {
+		return stripScheme(strings.TrimSuffix(host, "/"))
+	}
+	if serverURL := os.Getenv("CI_SERVER_URL"); serverURL != "" {
+		return stripScheme(strings.TrimSuffix(serverURL, "/"))
+	}
+	return defaultGitLabHost
+}
+
+// stripScheme removes a leading "https://" or "http://" from host, so it can
+// be safely re-prefixed with "https://" when building request URLs.
+func stripScheme(host string) string {
+	host = strings.TrimPrefix(host, "https://")
+	host = strings.TrimPrefix(host, "http://")
+	return host
+}
+
+// apiVersionPathFromEnv resolves the REST API version path, defaulting to
+// "api/v4" but allowing `gitlab_api_version` to override it (e.g. for a
+// self-hosted instance pinned to a different API version).
+func apiVersionPathFromEnv() string {
+	return strings.TrimSpace(os.Getenv("gitlab_api_version"))
+}
@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+func TestResolveGitLabHostStripsSchemeFromGitlabHost(t *testing.T) {
+	t.Setenv("gitlab_host", "https://gitlab.example.com/")
+	t.Setenv("CI_SERVER_URL", "")
+
+	if got := resolveGitLabHost(); got != "gitlab.example.com" {
+		t.Fatalf("expected scheme to be stripped from gitlab_host, got %q", got)
+	}
+}
+
+func TestResolveGitLabHostStripsSchemeFromCIServerURL(t *testing.T) {
+	t.Setenv("gitlab_host", "")
+	t.Setenv("CI_SERVER_URL", "https://gitlab.example.com")
+
+	if got := resolveGitLabHost(); got != "gitlab.example.com" {
+		t.Fatalf("expected scheme to be stripped from CI_SERVER_URL, got %q", got)
+	}
+}
+
+func TestResolveGitLabHostDefaultsToGitlabCom(t *testing.T) {
+	t.Setenv("gitlab_host", "")
+	t.Setenv("CI_SERVER_URL", "")
+
+	if got := resolveGitLabHost(); got != defaultGitLabHost {
+		t.Fatalf("expected default host %q, got %q", defaultGitLabHost, got)
+	}
+}
+
+func TestApiVersionPathFromEnvOverride(t *testing.T) {
+	t.Setenv("gitlab_api_version", "api/v5")
+
+	if got := apiVersionPathFromEnv(); got != "api/v5" {
+		t.Fatalf("expected override 'api/v5', got %q", got)
+	}
+}
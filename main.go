@@ -3,20 +3,16 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"net/url"
 	"os"
+	"strconv"
 	"strings"
-)
 
-const (
-	baseAPIURL   = "https://gitlab.com/api/v4/projects"
-	graphqlURL   = "https://gitlab.com/api/graphql"
-	statusesPath = "/%s/statuses/%s"  // Format: /:projectPath/statuses/:commitSHA
-	jobsPath     = "/%s/jobs/%s/play" // Format: /:projectPath/jobs/:jobID/play
+	gitlab "github.com/xanzy/go-gitlab"
 )
 
 // GitLabStatus represents the possible states in GitLab
@@ -40,61 +36,62 @@ func (s GitLabStatus) IsValid() bool {
 	return false
 }
 
-// GraphQLResponse structure to parse the pipeline query response
-type GraphQLResponse struct {
-	Data struct {
-		Project struct {
-			Name      string `json:"name"`
-			Pipelines struct {
-				Nodes []struct {
-					ID     string `json:"id"`  // Global pipeline ID
-					IID    string `json:"iid"` // Short pipeline ID
-					Status string `json:"status"`
-					Jobs   struct {
-						Nodes []struct {
-							ID         string `json:"id"`         // Job global ID
-							Name       string `json:"name"`       // Job name
-							Status     string `json:"status"`     // Job status
-							CanPlayJob bool   `json:"canPlayJob"` // Can this job be played
-						} `json:"nodes"`
-					} `json:"jobs"`
-				} `json:"nodes"`
-			} `json:"pipelines"`
-		} `json:"project"`
-	} `json:"data"`
-}
-
 func main() {
+	// `ci trace <project-path> <job-id>` streams a job's log until it
+	// reaches a terminal status; it's a separate mode from the default
+	// trigger flow below since it doesn't need the Bitrise build env vars.
+	if len(os.Args) > 1 && os.Args[1] == "trace" {
+		client, err := NewGitLabClientFromEnv(os.Getenv("gitlab_token"))
+		if err != nil {
+			log.Fatalf("Failed to initialize GitLab client: %v", err)
+		}
+		runTrace(client, os.Args[2:])
+		return
+	}
+
 	// Fetch environment variables
 	projectPath, branchName, jobName, gitlabToken, buildStatus, buildSHA, buildURL := fetchEnvVars()
 
 	// Determine build status state
 	status := buildStatusToState(buildStatus)
 
-	// Fetch pipelines for the commit
-	response := fetchPipelines(projectPath, *buildSHA, branchName, gitlabToken)
+	client, err := NewGitLabClientFromEnv(gitlabToken)
+	if err != nil {
+		log.Fatalf("Failed to initialize GitLab client: %v", err)
+	}
+
+	// Fetch pipelines for the commit, following downstream/child pipelines
+	// up to gitlab_max_depth levels so jobs in triggered pipelines resolve too.
+	maxDepth := maxPipelineDepthFromEnv()
+	response := fetchPipelines(client, projectPath, *buildSHA, branchName, maxDepth)
 
-	// Find the job and its associated pipeline ID
-	jobID, pipelineID := findJobAndPipeline(response, jobName)
+	// Find the job and its associated pipeline, wherever in the tree it lives.
+	resolved, err := resolveJob(response, projectPath, jobName)
+	switch {
+	case errors.Is(err, ErrJobNotFound):
+		log.Fatalf("No job named '%s' found in pipeline tree (max depth %d)", jobName, maxDepth)
+	case errors.Is(err, ErrJobNotPlayable):
+		log.Fatalf("Job '%s' found in project '%s' but not playable yet (status '%s')", jobName, resolved.ProjectPath, resolved.Status)
+	}
 
-	log.Printf("Build Job id '%s'", jobID)
+	log.Printf("Build Job id '%s'", resolved.JobID)
 	log.Printf("Build SHA '%s'", safeString(buildSHA, "not provided"))
 	log.Printf("Build Branch '%s'", safeString(branchName, "not provided"))
 	log.Printf("Build URL '%s'", buildURL)
 	log.Printf("Build Status '%s'", status)
-	log.Printf("Build Pipelines '%s'", pipelineID)
-
-	if jobID == "" || pipelineID == "" {
-		log.Fatalf("No playable job or pipeline found for job '%s'", jobName)
-	}
+	log.Printf("Build Pipelines '%s'", resolved.PipelineIID)
 
-	// Publish Bitrise build status to GitLab
-	// publishBuildStatus(projectPath, pipelineID, buildSHA, status, gitlabToken, buildURL)
+	// Publish the Bitrise build status to GitLab as a commit status.
+	publishBuildStatus(client, resolved.ProjectPath, resolved.PipelineIID, *buildSHA, safeString(branchName, ""), status, buildURL, statusContextFromEnv(), os.Getenv("gitlab_dry_run") == "true")
 
 	// Trigger the job if build status is "success"
 	if status == Success {
 		fmt.Println("Build status indicates success. Proceeding to trigger the job.")
-		triggerJob(projectPath, jobID, gitlabToken)
+		triggerJob(client, resolved.ProjectPath, resolved.JobID)
+
+		if os.Getenv("gitlab_wait_for_job") == "true" {
+			runTrace(client, []string{resolved.ProjectPath, extractLastComponent(resolved.JobID)})
+		}
 	} else {
 		fmt.Printf("Build status is '%s'. Skipping job trigger.\n", status)
 	}
@@ -164,28 +161,11 @@ func buildStatusToState(buildStatus string) GitLabStatus {
 }
 
 // fetchPipelines sends the GraphQL query to GitLab and returns the parsed response.
-func fetchPipelines(projectPath string, sha string, branchName *string, gitlabToken string) GraphQLResponse {
-	query := `
-	query GetPipelinesForCommit($projectPath: ID!, $sha: String!) {
-		project(fullPath: $projectPath) {
-			name
-			pipelines(sha: $sha) {
-				nodes {
-					id
-					iid
-					status
-					jobs {
-						nodes {
-							id
-							name
-							status
-							canPlayJob
-						}
-					}
-				}
-			}
-		}
-	}`
+// go-gitlab doesn't speak GraphQL, so this keeps hitting the GraphQL endpoint
+// directly, but reuses the shared client's retryable HTTP transport rather
+// than building a fresh http.Client per call.
+func fetchPipelines(client *GitLabClient, projectPath string, sha string, branchName *string, maxDepth int) GraphQLResponse {
+	query := pipelinesQuery(maxDepth)
 
 	// Construct the variables map
 	var variables = map[string]interface{}{
@@ -207,15 +187,15 @@ func fetchPipelines(projectPath string, sha string, branchName *string, gitlabTo
 		log.Fatalf("Failed to marshal GraphQL query: %v", err)
 	}
 
-	req, err := http.NewRequest("POST", graphqlURL, bytes.NewBuffer(jsonBody))
+	req, err := http.NewRequest("POST", client.GraphQLURL, bytes.NewBuffer(jsonBody))
 	if err != nil {
 		log.Fatalf("Failed to create HTTP request: %v", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+gitlabToken)
+	authHeader, authValue := client.AuthHeader()
+	req.Header.Set(authHeader, authValue)
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := client.HTTP.Do(req)
 	if err != nil {
 		log.Fatalf("Request failed: %v", err)
 	}
@@ -238,18 +218,6 @@ func fetchPipelines(projectPath string, sha string, branchName *string, gitlabTo
 	return gqlResponse
 }
 
-// findJobAndPipeline searches for a playable job and returns its ID and associated pipeline ID.
-func findJobAndPipeline(response GraphQLResponse, jobName string) (string, string) {
-	for _, pipeline := range response.Data.Project.Pipelines.Nodes {
-		for _, job := range pipeline.Jobs.Nodes {
-			if job.Name == jobName { // } && job.CanPlayJob {
-				return job.ID, extractLastComponent(pipeline.ID)
-			}
-		}
-	}
-	return "", ""
-}
-
 // extractLastComponent extracts the last component of a string separated by '/'
 func extractLastComponent(fullID string) string {
 	parts := strings.Split(fullID, "/")
@@ -263,91 +231,34 @@ func safeString(ptr *string, fallback string) string {
 	return *ptr
 }
 
-// publishBuildStatus sends the Bitrise build status to GitLab for the specified commit SHA and pipeline ID.
-func publishBuildStatus(projectPath, pipelineID, commitSHA string, status GitLabStatus, gitlabToken, buildURL string) {
-	if !status.IsValid() {
-		log.Fatalf("Invalid status '%s' provided.", status)
-	}
-
-	statusUpdateEndpoint := fmt.Sprintf(baseAPIURL+statusesPath, url.PathEscape(projectPath), commitSHA)
-
-	formData := url.Values{}
-	formData.Set("name", "Bitrise.io")
-	formData.Set("state", string(status)) // Convert GitLabStatus to string
-	formData.Set("target_url", buildURL)
-	formData.Set("description", "Bitrise build status update")
-	formData.Set("pipeline_id", pipelineID)
-
-	req, err := http.NewRequest("POST", statusUpdateEndpoint, bytes.NewBufferString(formData.Encode()))
-	if err != nil {
-		log.Fatalf("Failed to create status update request: %v", err)
-	}
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.Header.Set("Authorization", "Bearer "+gitlabToken)
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
+// triggerJob sends a request to play the specified job.
+func triggerJob(client *GitLabClient, projectPath, jobID string) {
+	jobIDInt, err := strconv.Atoi(extractLastComponent(jobID))
 	if err != nil {
-		log.Fatalf("Failed to send status update request: %v", err)
+		log.Fatalf("Failed to parse job ID '%s': %v", jobID, err)
 	}
-	defer resp.Body.Close()
+	fmt.Printf("Triggering job with id '%d' on project '%s'.\n", jobIDInt, projectPath)
 
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
-		body, _ := io.ReadAll(resp.Body)
-		log.Fatalf("Failed to update status. Status: %d, Response: %s", resp.StatusCode, string(body))
-	}
-
-	fmt.Printf("Successfully updated build status to '%s' for commit SHA '%s'.\n", status, commitSHA)
-}
-
-// triggerJob sends a request to play the specified job.
-func triggerJob(projectPath, jobID, gitlabToken string) {
-	apiURL := fmt.Sprintf(baseAPIURL+jobsPath, url.PathEscape(projectPath), extractLastComponent(jobID))
-	fmt.Printf("Triggering job with id '%s' - url '%s'.\n", jobID, apiURL)
-
-	jobVariables := []map[string]string{
-		{"key": "BITRISE_API_TOKEN", "value": os.Getenv("BITRISE_API_TOKEN")},
-		{"key": "BITRISE_APP_SLUG", "value": os.Getenv("BITRISE_APP_SLUG")},
-		{"key": "BITRISE_BUILD_SLUG", "value": os.Getenv("BITRISE_BUILD_SLUG")},
+	jobVariables := []*gitlab.JobVariableOptions{
+		{Key: gitlab.Ptr("BITRISE_API_TOKEN"), Value: gitlab.Ptr(os.Getenv("BITRISE_API_TOKEN"))},
+		{Key: gitlab.Ptr("BITRISE_APP_SLUG"), Value: gitlab.Ptr(os.Getenv("BITRISE_APP_SLUG"))},
+		{Key: gitlab.Ptr("BITRISE_BUILD_SLUG"), Value: gitlab.Ptr(os.Getenv("BITRISE_BUILD_SLUG"))},
 	}
 
 	// Ensure all required variables are set
 	for _, v := range jobVariables {
-		if v["value"] == "" {
-			log.Fatalf("Environment variable %s must be set.", v["key"])
+		if v.Value == nil || *v.Value == "" {
+			log.Fatalf("Environment variable %s must be set.", *v.Key)
 		}
 	}
 
 	fmt.Println("Job Variables:")
 	for _, v := range jobVariables {
-		fmt.Printf("%s: %s\n", v["key"], v["value"])
+		fmt.Printf("%s: %s\n", *v.Key, *v.Value)
 	}
 
-	requestBody := map[string]interface{}{
-		"job_variables_attributes": jobVariables,
-	}
-	jsonBody, err := json.Marshal(requestBody)
-	if err != nil {
-		log.Fatalf("Failed to marshal request body: %v", err)
-	}
-
-	req, err := http.NewRequest("POST", apiURL, bytes.NewBuffer(jsonBody))
-	if err != nil {
-		log.Fatalf("Failed to create HTTP request for job trigger: %v", err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("PRIVATE-TOKEN", gitlabToken)
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Fatalf("Failed to send job trigger request: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
-		body, _ := io.ReadAll(resp.Body)
-		log.Fatalf("Failed to trigger job. Status: %d, Response: %s", resp.StatusCode, string(body))
+	if err := playJobIdempotent(client.REST.Jobs, projectPath, jobIDInt, jobVariables, retryIfStatusFromEnv()); err != nil {
+		log.Fatalf("Failed to trigger job: %v", err)
 	}
 
 	fmt.Println("Job successfully triggered.")
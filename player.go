@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	gitlab "github.com/xanzy/go-gitlab"
+)
+
+// JobPlayer is the subset of go-gitlab's JobsService that triggerJob needs.
+// Abstracting it behind an interface lets the duplicate-play logic below be
+// unit-tested against an httptest.Server instead of the real GitLab API.
+type JobPlayer interface {
+	GetJob(pid interface{}, jobID int, options ...gitlab.RequestOptionFunc) (*gitlab.Job, *gitlab.Response, error)
+	PlayJob(pid interface{}, jobID int, opt *gitlab.PlayJobOptions, options ...gitlab.RequestOptionFunc) (*gitlab.Job, *gitlab.Response, error)
+}
+
+// alreadyTriggeredStatuses are the job statuses that mean a previous trigger
+// already took effect, so a second `play` call should be treated as a
+// harmless no-op rather than an error.
+var alreadyTriggeredStatuses = map[string]bool{
+	"pending": true,
+	"running": true,
+	"success": true,
+}
+
+// playJobIdempotent plays projectPath's jobID. Transient failures (network
+// errors, 5xx, 429) are already retried by go-gitlab's own client, so this
+// doesn't add a second retry layer on top -- its job is detecting GitLab's
+// "Unplayable Job" 400 response and treating it as success rather than an
+// error if the job has already moved into a running/terminal state by the
+// time we check.
+//
+// When retryIfStatus is non-empty, the job is only (re)triggered if its
+// current status is in that set (e.g. "manual,skipped"); otherwise it's
+// assumed to already be in flight and the call is a no-op. This is what
+// lets a Bitrise re-run call this step again without double-triggering a
+// job that's still running from the first pass.
+func playJobIdempotent(player JobPlayer, projectPath string, jobID int, variables []*gitlab.JobVariableOptions, retryIfStatus []string) error {
+	if len(retryIfStatus) > 0 {
+		job, _, err := player.GetJob(projectPath, jobID)
+		if err != nil {
+			return fmt.Errorf("failed to fetch job %d before triggering: %w", jobID, err)
+		}
+		if !containsStatus(retryIfStatus, job.Status) {
+			log.Printf("Job %d is already '%s', not in --retry-if-status=%s; skipping trigger.", jobID, job.Status, strings.Join(retryIfStatus, ","))
+			return nil
+		}
+	}
+
+	_, resp, err := player.PlayJob(projectPath, jobID, &gitlab.PlayJobOptions{
+		JobVariablesAttributes: &variables,
+	})
+	if err == nil {
+		return nil
+	}
+
+	if resp != nil && resp.StatusCode == http.StatusBadRequest && isUnplayableJobError(err) {
+		if job, _, getErr := player.GetJob(projectPath, jobID); getErr == nil && alreadyTriggeredStatuses[job.Status] {
+			log.Printf("Job %d already '%s'; treating duplicate play as a no-op.", jobID, job.Status)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("failed to play job %d: %w", jobID, err)
+}
+
+// isUnplayableJobError reports whether err is GitLab's "400 Unplayable Job"
+// response, which it returns for jobs that can't be played right now (e.g.
+// already running, or blocked on an earlier stage).
+func isUnplayableJobError(err error) bool {
+	return strings.Contains(strings.ToLower(err.Error()), "unplayable job")
+}
+
+func containsStatus(statuses []string, status string) bool {
+	for _, s := range statuses {
+		if strings.TrimSpace(s) == status {
+			return true
+		}
+	}
+	return false
+}
+
+// retryIfStatusFromEnv parses the comma-separated `gitlab_retry_if_status`
+// environment variable (e.g. "manual,skipped") into a slice, empty when
+// unset.
+func retryIfStatusFromEnv() []string {
+	raw := strings.TrimSpace(os.Getenv("gitlab_retry_if_status"))
+	if raw == "" {
+		return nil
+	}
+	parts := strings.Split(raw, ",")
+	statuses := make([]string, 0, len(parts))
+	for _, p := range parts {
+		if p = strings.TrimSpace(p); p != "" {
+			statuses = append(statuses, p)
+		}
+	}
+	return statuses
+}
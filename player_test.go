@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	gitlab "github.com/xanzy/go-gitlab"
+)
+
+// newTestJobPlayer points a real go-gitlab client at an httptest.Server so
+// playJobIdempotent's duplicate-play detection runs against actual HTTP
+// responses instead of a hand-rolled stub.
+func newTestJobPlayer(t *testing.T, handler http.HandlerFunc) JobPlayer {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	gl, err := gitlab.NewClient("test-token", gitlab.WithBaseURL(server.URL))
+	if err != nil {
+		t.Fatalf("failed to build test gitlab client: %v", err)
+	}
+	return gl.Jobs
+}
+
+func TestPlayJobIdempotentSucceedsOnFirstTry(t *testing.T) {
+	player := newTestJobPlayer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(gitlab.Job{ID: 1, Status: "pending"})
+	})
+
+	if err := playJobIdempotent(player, "group/project", 1, nil, nil); err != nil {
+		t.Fatalf("expected success, got error: %v", err)
+	}
+}
+
+func TestPlayJobIdempotentTreatsUnplayableAlreadyRunningAsNoOp(t *testing.T) {
+	var playCalls int
+	player := newTestJobPlayer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodPost {
+			playCalls++
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"message": "400 Unplayable Job"})
+			return
+		}
+		json.NewEncoder(w).Encode(gitlab.Job{ID: 1, Status: "running"})
+	})
+
+	if err := playJobIdempotent(player, "group/project", 1, nil, nil); err != nil {
+		t.Fatalf("expected duplicate-play to be treated as a no-op, got error: %v", err)
+	}
+	if playCalls != 1 {
+		t.Fatalf("expected exactly one play attempt, got %d", playCalls)
+	}
+}
+
+func TestPlayJobIdempotentSkipsTriggerWhenStatusNotInRetryIfStatus(t *testing.T) {
+	var playCalls int
+	player := newTestJobPlayer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodPost {
+			playCalls++
+		}
+		json.NewEncoder(w).Encode(gitlab.Job{ID: 1, Status: "running"})
+	})
+
+	if err := playJobIdempotent(player, "group/project", 1, nil, []string{"manual", "skipped"}); err != nil {
+		t.Fatalf("expected no-op, got error: %v", err)
+	}
+	if playCalls != 0 {
+		t.Fatalf("expected no play attempt since job isn't manual/skipped, got %d", playCalls)
+	}
+}
+
+func TestPlayJobIdempotentSucceedsAfterGoGitlabRetriesA5xx(t *testing.T) {
+	var attempts int
+	player := newTestJobPlayer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != http.MethodPost {
+			json.NewEncoder(w).Encode(gitlab.Job{ID: 1, Status: "created"})
+			return
+		}
+
+		attempts++
+		if attempts < 2 {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"message": "internal error"})
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(gitlab.Job{ID: 1, Status: "pending"})
+	})
+
+	// playJobIdempotent makes a single PlayJob call; the retry across the
+	// transient 500 happens inside go-gitlab's own client.
+	if err := playJobIdempotent(player, "group/project", 1, nil, nil); err != nil {
+		t.Fatalf("expected eventual success after retry, got error: %v", err)
+	}
+	if attempts != 2 {
+		t.Fatalf("expected exactly 2 play attempts, got %d", attempts)
+	}
+}
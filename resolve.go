@@ -0,0 +1,207 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+const defaultMaxPipelineDepth = 3
+
+// ErrJobNotFound is returned when no job with the requested name exists
+// anywhere in the pipeline tree that was searched.
+var ErrJobNotFound = errors.New("job not found in pipeline tree")
+
+// ErrJobNotPlayable is returned when a job with the requested name was
+// found, but GitLab doesn't consider it playable yet (e.g. it's still
+// waiting on earlier stages). The ResolvedJob is still returned alongside
+// this error so callers can report where the job is stuck.
+var ErrJobNotPlayable = errors.New("job found but not playable yet")
+
+// ResolvedJob identifies a job located somewhere in a pipeline's tree of
+// downstream (trigger/bridge) pipelines, along with the project it actually
+// lives in -- multi-project downstream pipelines can belong to a different
+// project than the one the search started from.
+type ResolvedJob struct {
+	ProjectPath string
+	// PipelineIID is the numeric pipeline ID extracted from the GraphQL
+	// global ID (e.g. "gid://gitlab/Ci::Pipeline/123" -> "123"), which is
+	// what the REST API's pipeline_id parameters expect -- not GitLab's
+	// project-relative display IID.
+	PipelineIID string
+	JobID       string
+	Name        string
+	Status      string
+	CanPlay     bool
+}
+
+// jobNode mirrors a single `jobs.nodes` entry in the GraphQL response.
+type jobNode struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	CanPlayJob bool   `json:"canPlayJob"`
+}
+
+// pipelineNode mirrors a single pipeline in the GraphQL response, including
+// its downstream (trigger/bridge) pipelines. It's recursive: downstream
+// pipelines carry the same shape, down to whatever depth the query asked
+// for.
+type pipelineNode struct {
+	ID     string `json:"id"`
+	IID    string `json:"iid"`
+	Status string `json:"status"`
+	Jobs   struct {
+		Nodes []jobNode `json:"nodes"`
+	} `json:"jobs"`
+	DownstreamPipelines struct {
+		Nodes []pipelineNode `json:"nodes"`
+	} `json:"downstreamPipelines"`
+	Project struct {
+		FullPath string `json:"fullPath"`
+	} `json:"project"`
+}
+
+// GraphQLResponse structure to parse the pipeline query response
+type GraphQLResponse struct {
+	Data struct {
+		Project struct {
+			Name      string `json:"name"`
+			Pipelines struct {
+				Nodes []pipelineNode `json:"nodes"`
+			} `json:"pipelines"`
+		} `json:"project"`
+	} `json:"data"`
+}
+
+// pipelinesQuery builds the GraphQL query used by fetchPipelines. GraphQL
+// has no concept of unbounded recursion, so downstreamPipelines are nested
+// literally maxDepth times; maxDepth <= 0 means "don't follow downstream
+// pipelines at all".
+func pipelinesQuery(maxDepth int) string {
+	return fmt.Sprintf(`
+	query GetPipelinesForCommit($projectPath: ID!, $sha: String!) {
+		project(fullPath: $projectPath) {
+			name
+			pipelines(sha: $sha) {
+				nodes {
+					id
+					iid
+					status
+					%s
+				}
+			}
+		}
+	}`, pipelineFieldsFragment(maxDepth))
+}
+
+// pipelineFieldsFragment returns the `jobs { ... }` selection for a pipeline
+// node, followed by a `downstreamPipelines { ... }` selection nested
+// `depth` levels deep.
+func pipelineFieldsFragment(depth int) string {
+	jobs := `jobs {
+					nodes {
+						id
+						name
+						status
+						canPlayJob
+					}
+				}`
+	if depth <= 0 {
+		return jobs
+	}
+	return jobs + fmt.Sprintf(`
+				downstreamPipelines {
+					nodes {
+						id
+						iid
+						status
+						project {
+							fullPath
+						}
+						%s
+					}
+				}`, pipelineFieldsFragment(depth-1))
+}
+
+// maxPipelineDepthFromEnv reads `gitlab_max_depth`, falling back to
+// defaultMaxPipelineDepth when it's unset or not a valid integer.
+func maxPipelineDepthFromEnv() int {
+	raw := os.Getenv("gitlab_max_depth")
+	if raw == "" {
+		return defaultMaxPipelineDepth
+	}
+	depth, err := strconv.Atoi(raw)
+	if err != nil || depth < 0 {
+		return defaultMaxPipelineDepth
+	}
+	return depth
+}
+
+// resolveJob searches a pipeline tree breadth-first for a job named
+// jobName, starting from the top-level pipelines of projectPath and
+// following downstream/child pipelines (which may belong to other
+// projects, in the case of multi-project pipelines). A visited set on
+// pipeline IDs guards against cycles.
+//
+// If a matching job is found but isn't playable yet, it's still returned,
+// wrapped in ErrJobNotPlayable, so callers can distinguish "not found" from
+// "found, but not ready".
+func resolveJob(response GraphQLResponse, projectPath, jobName string) (*ResolvedJob, error) {
+	type queueEntry struct {
+		pipeline    pipelineNode
+		projectPath string
+	}
+
+	queue := make([]queueEntry, 0, len(response.Data.Project.Pipelines.Nodes))
+	for _, p := range response.Data.Project.Pipelines.Nodes {
+		queue = append(queue, queueEntry{pipeline: p, projectPath: projectPath})
+	}
+
+	visited := map[string]bool{}
+	var notPlayable *ResolvedJob
+
+	for len(queue) > 0 {
+		entry := queue[0]
+		queue = queue[1:]
+
+		if entry.pipeline.ID != "" {
+			if visited[entry.pipeline.ID] {
+				continue
+			}
+			visited[entry.pipeline.ID] = true
+		}
+
+		for _, job := range entry.pipeline.Jobs.Nodes {
+			if job.Name != jobName {
+				continue
+			}
+			resolved := &ResolvedJob{
+				ProjectPath: entry.projectPath,
+				PipelineIID: extractLastComponent(entry.pipeline.ID),
+				JobID:       job.ID,
+				Name:        job.Name,
+				Status:      job.Status,
+				CanPlay:     job.CanPlayJob,
+			}
+			if job.CanPlayJob {
+				return resolved, nil
+			}
+			notPlayable = resolved
+		}
+
+		for _, child := range entry.pipeline.DownstreamPipelines.Nodes {
+			childProjectPath := entry.projectPath
+			if child.Project.FullPath != "" {
+				childProjectPath = child.Project.FullPath
+			}
+			queue = append(queue, queueEntry{pipeline: child, projectPath: childProjectPath})
+		}
+	}
+
+	if notPlayable != nil {
+		return notPlayable, ErrJobNotPlayable
+	}
+	return nil, ErrJobNotFound
+}
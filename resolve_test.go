@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func unmarshalResponse(t *testing.T, raw string) GraphQLResponse {
+	t.Helper()
+	var response GraphQLResponse
+	if err := json.Unmarshal([]byte(raw), &response); err != nil {
+		t.Fatalf("failed to unmarshal fixture: %v", err)
+	}
+	return response
+}
+
+func TestResolveJobTopLevel(t *testing.T) {
+	response := unmarshalResponse(t, `{
+		"data": {
+			"project": {
+				"name": "app",
+				"pipelines": {
+					"nodes": [{
+						"id": "gid://gitlab/Ci::Pipeline/1",
+						"iid": "1",
+						"status": "running",
+						"jobs": {
+							"nodes": [
+								{"id": "gid://gitlab/Ci::Build/10", "name": "ios-ui-tests", "status": "manual", "canPlayJob": true}
+							]
+						}
+					}]
+				}
+			}
+		}
+	}`)
+
+	resolved, err := resolveJob(response, "mobile/app", "ios-ui-tests")
+	if err != nil {
+		t.Fatalf("expected a resolved job, got error: %v", err)
+	}
+	if resolved.ProjectPath != "mobile/app" || resolved.PipelineIID != "1" || resolved.JobID != "gid://gitlab/Ci::Build/10" {
+		t.Fatalf("unexpected resolved job: %+v", resolved)
+	}
+}
+
+func TestResolveJobInDownstreamPipeline(t *testing.T) {
+	response := unmarshalResponse(t, `{
+		"data": {
+			"project": {
+				"name": "app",
+				"pipelines": {
+					"nodes": [{
+						"id": "gid://gitlab/Ci::Pipeline/1",
+						"iid": "1",
+						"status": "running",
+						"jobs": {
+							"nodes": [
+								{"id": "gid://gitlab/Ci::Bridge/11", "name": "trigger:mobile", "status": "success", "canPlayJob": false}
+							]
+						},
+						"downstreamPipelines": {
+							"nodes": [{
+								"id": "gid://gitlab/Ci::Pipeline/2",
+								"iid": "1",
+								"status": "running",
+								"project": {"fullPath": "mobile/ios-app"},
+								"jobs": {
+									"nodes": [
+										{"id": "gid://gitlab/Ci::Build/20", "name": "ios-ui-tests", "status": "manual", "canPlayJob": true}
+									]
+								}
+							}]
+						}
+					}]
+				}
+			}
+		}
+	}`)
+
+	resolved, err := resolveJob(response, "mobile/umbrella", "ios-ui-tests")
+	if err != nil {
+		t.Fatalf("expected a resolved job, got error: %v", err)
+	}
+	if resolved.ProjectPath != "mobile/ios-app" {
+		t.Fatalf("expected downstream job to resolve to its own project, got %q", resolved.ProjectPath)
+	}
+	if resolved.PipelineIID != "2" {
+		t.Fatalf("expected pipeline ID '2', got %q", resolved.PipelineIID)
+	}
+}
+
+func TestResolveJobNotPlayableYet(t *testing.T) {
+	response := unmarshalResponse(t, `{
+		"data": {
+			"project": {
+				"name": "app",
+				"pipelines": {
+					"nodes": [{
+						"id": "gid://gitlab/Ci::Pipeline/1",
+						"iid": "1",
+						"status": "running",
+						"jobs": {
+							"nodes": [
+								{"id": "gid://gitlab/Ci::Build/10", "name": "ios-ui-tests", "status": "created", "canPlayJob": false}
+							]
+						}
+					}]
+				}
+			}
+		}
+	}`)
+
+	resolved, err := resolveJob(response, "mobile/app", "ios-ui-tests")
+	if !errors.Is(err, ErrJobNotPlayable) {
+		t.Fatalf("expected ErrJobNotPlayable, got %v", err)
+	}
+	if resolved == nil || resolved.Status != "created" {
+		t.Fatalf("expected the not-yet-playable job to still be returned, got %+v", resolved)
+	}
+}
+
+func TestResolveJobNotFound(t *testing.T) {
+	response := unmarshalResponse(t, `{
+		"data": {
+			"project": {
+				"name": "app",
+				"pipelines": {
+					"nodes": [{
+						"id": "gid://gitlab/Ci::Pipeline/1",
+						"iid": "1",
+						"status": "running",
+						"jobs": {"nodes": []}
+					}]
+				}
+			}
+		}
+	}`)
+
+	resolved, err := resolveJob(response, "mobile/app", "ios-ui-tests")
+	if !errors.Is(err, ErrJobNotFound) {
+		t.Fatalf("expected ErrJobNotFound, got %v", err)
+	}
+	if resolved != nil {
+		t.Fatalf("expected a nil resolved job, got %+v", resolved)
+	}
+}
+
+// TestResolveJobHandlesPipelineCycles guards the visited-set: a pipeline
+// that (incorrectly) lists itself as its own downstream pipeline must not
+// send resolveJob into an infinite loop.
+func TestResolveJobHandlesPipelineCycles(t *testing.T) {
+	cyclic := pipelineNode{
+		ID:     "gid://gitlab/Ci::Pipeline/1",
+		IID:    "1",
+		Status: "running",
+	}
+	cyclic.Jobs.Nodes = []jobNode{
+		{ID: "gid://gitlab/Ci::Build/10", Name: "ios-ui-tests", Status: "manual", CanPlayJob: true},
+	}
+	cyclic.DownstreamPipelines.Nodes = []pipelineNode{cyclic}
+
+	var response GraphQLResponse
+	response.Data.Project.Pipelines.Nodes = []pipelineNode{cyclic}
+
+	resolved, err := resolveJob(response, "mobile/app", "ios-ui-tests")
+	if err != nil {
+		t.Fatalf("expected the job to resolve despite the cycle, got error: %v", err)
+	}
+	if resolved.JobID != "gid://gitlab/Ci::Build/10" {
+		t.Fatalf("unexpected resolved job: %+v", resolved)
+	}
+}
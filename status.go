@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	gitlab "github.com/xanzy/go-gitlab"
+)
+
+// terminalCommitStatuses are the commit status states GitLab won't let you
+// transition away from; re-posting one of these is a no-op, not an error.
+var terminalCommitStatuses = map[string]bool{
+	"success":  true,
+	"failed":   true,
+	"canceled": true,
+}
+
+// publishBuildStatus sends the Bitrise build status to GitLab as a commit
+// status for the specified commit SHA and pipeline. context namespaces the
+// status (e.g. "bitrise/ios-ui-tests") so multiple Bitrise workflows can
+// post independent statuses to the same commit without clobbering each
+// other. When dryRun is set, the payload is logged but never sent.
+func publishBuildStatus(client *GitLabClient, projectPath, pipelineID, commitSHA, branchName string, status GitLabStatus, buildURL, context string, dryRun bool) {
+	if !status.IsValid() {
+		log.Fatalf("Invalid status '%s' provided.", status)
+	}
+
+	pipelineIDInt, err := strconv.Atoi(pipelineID)
+	if err != nil {
+		log.Fatalf("Failed to parse pipeline ID '%s': %v", pipelineID, err)
+	}
+
+	// GitLab's commit status API has a single underlying field for this --
+	// "context" is just the legacy name for it -- so only set Name here.
+	// Setting both with different values would mean the namespacing below
+	// silently loses to whichever one the client library prioritizes.
+	opt := &gitlab.SetCommitStatusOptions{
+		State:       gitlab.BuildStateValue(status),
+		Name:        gitlab.Ptr(context),
+		TargetURL:   gitlab.Ptr(buildURL),
+		Description: gitlab.Ptr("Bitrise build status update"),
+		PipelineID:  gitlab.Ptr(pipelineIDInt),
+	}
+	if branchName != "" {
+		opt.Ref = gitlab.Ptr(branchName)
+	}
+
+	if dryRun {
+		log.Printf("[dry-run] would set commit status for '%s'@%s: context=%s state=%s target_url=%s pipeline_id=%d",
+			projectPath, commitSHA, context, status, buildURL, pipelineIDInt)
+		return
+	}
+
+	_, resp, err := client.REST.Commits.SetCommitStatus(projectPath, commitSHA, opt)
+	if err != nil {
+		if resp != nil && resp.StatusCode == 400 && isCannotTransitionError(err) {
+			if skipped := skipIfAlreadyTerminal(client, projectPath, commitSHA, context); skipped {
+				return
+			}
+		}
+		log.Fatalf("Failed to update status: %v", err)
+	}
+
+	fmt.Printf("Successfully updated build status to '%s' for commit SHA '%s'.\n", status, commitSHA)
+}
+
+// skipIfAlreadyTerminal looks up the commit's existing statuses for
+// context; if one is already in a terminal state, GitLab's "Cannot
+// transition status" rejection is expected and safe to ignore.
+func skipIfAlreadyTerminal(client *GitLabClient, projectPath, commitSHA, context string) bool {
+	statuses, _, err := client.REST.Commits.GetCommitStatuses(projectPath, commitSHA, &gitlab.GetCommitStatusesOptions{})
+	if err != nil {
+		return false
+	}
+	for _, s := range statuses {
+		if s.Name == context && terminalCommitStatuses[s.Status] {
+			log.Printf("Status '%s' for context '%s' on commit '%s' is already terminal; skipping.", s.Status, context, commitSHA)
+			return true
+		}
+	}
+	return false
+}
+
+// isCannotTransitionError reports whether err is GitLab's 400 response for
+// a commit status that can't move to the requested state anymore.
+func isCannotTransitionError(err error) bool {
+	return strings.Contains(strings.ToLower(err.Error()), "cannot transition status")
+}
+
+// statusContextFromEnv resolves the commit status context/name, defaulting
+// to "Bitrise.io" for backward compatibility with existing installs, but
+// allowing `gitlab_status_context` to namespace it (e.g.
+// "bitrise/ios-ui-tests") so multiple workflows don't overwrite each other.
+func statusContextFromEnv() string {
+	if context := strings.TrimSpace(os.Getenv("gitlab_status_context")); context != "" {
+		return context
+	}
+	return "Bitrise.io"
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	gitlab "github.com/xanzy/go-gitlab"
+)
+
+func TestPublishBuildStatusNamespacesByContext(t *testing.T) {
+	var gotPayloads []gitlab.SetCommitStatusOptions
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Fatalf("failed to read request body: %v", err)
+		}
+		var opt gitlab.SetCommitStatusOptions
+		if err := json.Unmarshal(body, &opt); err != nil {
+			t.Fatalf("failed to unmarshal request body: %v", err)
+		}
+		gotPayloads = append(gotPayloads, opt)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(gitlab.CommitStatus{Status: "pending"})
+	}))
+	defer server.Close()
+
+	gl, err := gitlab.NewClient("test-token", gitlab.WithBaseURL(server.URL))
+	if err != nil {
+		t.Fatalf("failed to build test gitlab client: %v", err)
+	}
+	client := &GitLabClient{REST: gl}
+
+	publishBuildStatus(client, "group/project", "1", "deadbeef", "main", Pending, "https://ci.example/build/1", "bitrise/ios-ui-tests", false)
+	publishBuildStatus(client, "group/project", "1", "deadbeef", "main", Pending, "https://ci.example/build/1", "bitrise/android-ui-tests", false)
+
+	if len(gotPayloads) != 2 {
+		t.Fatalf("expected 2 requests, got %d", len(gotPayloads))
+	}
+	if got := *gotPayloads[0].Name; got != "bitrise/ios-ui-tests" {
+		t.Fatalf("expected first status name 'bitrise/ios-ui-tests', got %q", got)
+	}
+	if got := *gotPayloads[1].Name; got != "bitrise/android-ui-tests" {
+		t.Fatalf("expected second status name 'bitrise/android-ui-tests', got %q", got)
+	}
+	if gotPayloads[0].Context != nil || gotPayloads[1].Context != nil {
+		t.Fatalf("expected Context to be unset so it can't override Name, got %+v", gotPayloads)
+	}
+}
+
+func TestSkipIfAlreadyTerminalMatchesOnName(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode([]gitlab.CommitStatus{
+			{Name: "bitrise/ios-ui-tests", Status: "success"},
+		})
+	}))
+	defer server.Close()
+
+	gl, err := gitlab.NewClient("test-token", gitlab.WithBaseURL(server.URL))
+	if err != nil {
+		t.Fatalf("failed to build test gitlab client: %v", err)
+	}
+	client := &GitLabClient{REST: gl}
+
+	if !skipIfAlreadyTerminal(client, "group/project", "deadbeef", "bitrise/ios-ui-tests") {
+		t.Fatalf("expected a terminal status matching the context to be skippable")
+	}
+	if skipIfAlreadyTerminal(client, "group/project", "deadbeef", "bitrise/android-ui-tests") {
+		t.Fatalf("expected a different context to not match the existing status")
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	traceInitialBackoff = 1 * time.Second
+	traceMaxBackoff     = 5 * time.Second
+)
+
+// runTrace implements the `ci trace` subcommand: after a job has been
+// triggered, it streams the job's log to stdout by polling the trace
+// endpoint with an increasing byte offset, until the job reaches a terminal
+// status. This turns the step from fire-and-forget into a synchronous gate
+// that Bitrise's "wait for downstream" workflows can depend on.
+func runTrace(client *GitLabClient, args []string) {
+	fs := flag.NewFlagSet("trace", flag.ExitOnError)
+	timeout := fs.Duration("timeout", 30*time.Minute, "maximum time to wait for the job to finish")
+	fs.Parse(args)
+
+	rest := fs.Args()
+	if len(rest) < 2 {
+		log.Fatalf("usage: ci trace <project-path> <job-id> [--timeout=30m]")
+	}
+	projectPath := rest[0]
+	jobID, err := strconv.Atoi(rest[1])
+	if err != nil {
+		log.Fatalf("Invalid job ID '%s': %v", rest[1], err)
+	}
+
+	deadline := time.Now().Add(*timeout)
+	offset := 0
+	backoff := traceInitialBackoff
+
+	for {
+		if time.Now().After(deadline) {
+			log.Fatalf("Timed out after %s waiting for job %d to finish", *timeout, jobID)
+		}
+
+		n, status, err := writeTraceChunk(client, projectPath, jobID, offset)
+		if err != nil {
+			log.Fatalf("Failed to fetch job trace: %v", err)
+		}
+		offset += n
+
+		if isTerminalStatus(status) {
+			if status != string(Success) {
+				log.Fatalf("Job %d finished with status '%s'", jobID, status)
+			}
+			return
+		}
+
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > traceMaxBackoff {
+			backoff = traceMaxBackoff
+		}
+	}
+}
+
+// writeTraceChunk fetches the job's trace starting at offset, writes any new
+// bytes to stdout, and returns how many bytes were written along with the
+// job's current status. A 416 (Range Not Satisfiable) response means there's
+// no new data yet and is treated as a zero-byte, non-terminal chunk.
+func writeTraceChunk(client *GitLabClient, projectPath string, jobID, offset int) (int, string, error) {
+	traceURL := client.REST.BaseURL()
+	traceURL.Path += fmt.Sprintf("projects/%s/jobs/%d/trace", url.PathEscape(projectPath), jobID)
+
+	req, err := http.NewRequest("GET", traceURL.String(), nil)
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	authHeader, authValue := client.AuthHeader()
+	req.Header.Set(authHeader, authValue)
+
+	resp, err := client.HTTP.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	job, _, err := client.REST.Jobs.GetJob(projectPath, jobID)
+	if err != nil {
+		return 0, "", err
+	}
+
+	if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		return 0, job.Status, nil
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		body, _ := io.ReadAll(resp.Body)
+		return 0, "", fmt.Errorf("trace request failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	n, err := io.Copy(os.Stdout, resp.Body)
+	return int(n), job.Status, err
+}
+
+// isTerminalStatus reports whether a job status means it will not produce
+// any more trace output.
+func isTerminalStatus(status string) bool {
+	switch GitLabStatus(status) {
+	case Success, Failed, Canceled, Skipped:
+		return true
+	}
+	return false
+}